@@ -0,0 +1,307 @@
+package haproxyconfigurator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+// defaultRenewBefore matches the common "renew at 1/3 of lifetime remaining"
+// rule of thumb for 90 day ACME certificates.
+const defaultRenewBefore = 30 * 24 * time.Hour
+
+// ACMEResolverOptions configures an ACMEResolver.
+type ACMEResolverOptions struct {
+	// Email is the account contact address sent to the ACME server.
+	Email string
+	// CAServer is the ACME directory URL - e.g. Let's Encrypt's staging or
+	// production endpoint, or an internal server such as Boulder/step-ca.
+	CAServer string
+	// CACertificates is a list of PEM-encoded files added to the HTTP
+	// client's root pool, for talking to an ACME server whose issuing CA
+	// isn't in the system trust store.
+	CACertificates []string
+	// CASystemCertPool seeds the pool from x509.SystemCertPool() before
+	// CACertificates are added, rather than starting from an empty pool.
+	CASystemCertPool bool
+	// StorageDir is where issued certificates and the account key are
+	// persisted between restarts. The resolved PEM for a hostname lives at
+	// StorageDir/<hostname>.pem.
+	StorageDir string
+	// RenewBefore is how long before expiry a certificate is renewed.
+	// Defaults to defaultRenewBefore.
+	RenewBefore time.Duration
+	// HTTPChallengePort is the port the HTTP-01 challenge responder binds
+	// to. Defaults to 80.
+	HTTPChallengePort string
+}
+
+// acmeUser implements lego's registration.User.
+type acmeUser struct {
+	email        string
+	registration *registration.Resource
+	key          crypto.PrivateKey
+}
+
+func (u *acmeUser) GetEmail() string                        { return u.email }
+func (u *acmeUser) GetRegistration() *registration.Resource { return u.registration }
+func (u *acmeUser) GetPrivateKey() crypto.PrivateKey        { return u.key }
+
+type acmeCertificate struct {
+	path     string
+	notAfter time.Time
+}
+
+// ACMEResolver is a CertificateResolver backed by the ACME protocol
+// (HTTP-01 challenges today). It issues certificates on demand, persists
+// them under StorageDir, and renews them in the background before they
+// expire, notifying the owning HaproxyConfigurator so a fresh Render() can
+// be pushed out.
+type ACMEResolver struct {
+	options      ACMEResolverOptions
+	configurator *HaproxyConfigurator
+
+	client *lego.Client
+
+	mu    sync.Mutex
+	certs map[string]*acmeCertificate
+}
+
+// NewACMEResolver builds the ACME client's root certificate pool, registers
+// an account with the directory at options.CAServer, and starts the
+// background renewal loop. configurator is notified via
+// HaproxyConfigurator.OnConfigChange whenever a renewal rotates a
+// certificate out from under an already-rendered listener.
+func NewACMEResolver(options ACMEResolverOptions, configurator *HaproxyConfigurator) (*ACMEResolver, error) {
+	if options.RenewBefore == 0 {
+		options.RenewBefore = defaultRenewBefore
+	}
+	if options.HTTPChallengePort == "" {
+		options.HTTPChallengePort = "80"
+	}
+	if options.StorageDir == "" {
+		return nil, errors.New("ACMEResolverOptions.StorageDir is required")
+	}
+	if err := os.MkdirAll(options.StorageDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	httpClient, err := buildACMEHTTPClient(options)
+	if err != nil {
+		return nil, err
+	}
+
+	accountKey, err := loadOrCreateAccountKey(options.StorageDir)
+	if err != nil {
+		return nil, err
+	}
+	user := &acmeUser{email: options.Email, key: accountKey}
+
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = options.CAServer
+	legoConfig.HTTPClient = httpClient
+
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", options.HTTPChallengePort)); err != nil {
+		return nil, err
+	}
+
+	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return nil, err
+	}
+	user.registration = reg
+
+	certs, err := loadPersistedCertificates(options.StorageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := &ACMEResolver{
+		options:      options,
+		configurator: configurator,
+		client:       client,
+		certs:        certs,
+	}
+	go resolver.renewalLoop()
+	return resolver, nil
+}
+
+// loadOrCreateAccountKey reads the ACME account's ECDSA private key from
+// dir/account.key, generating and persisting one on first run so restarts
+// reuse the same account instead of registering a new one each time.
+func loadOrCreateAccountKey(dir string) (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(dir, "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("acme: " + path + " does not contain a PEM block")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadPersistedCertificates rebuilds the in-memory cache from the
+// <hostname>.pem files obtain previously wrote to dir, so a restart serves
+// existing certificates instead of re-obtaining every one of them from the
+// ACME server.
+func loadPersistedCertificates(dir string) (map[string]*acmeCertificate, error) {
+	certs := make(map[string]*acmeCertificate)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+		hostname := strings.TrimSuffix(entry.Name(), ".pem")
+		path := filepath.Join(dir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs[hostname] = &acmeCertificate{path: path, notAfter: cert.NotAfter}
+	}
+	return certs, nil
+}
+
+// Resolve returns the path to a PEM file for hostname, issuing it on first
+// use. Subsequent calls are served from the in-memory cache populated by
+// Resolve and by the renewal loop.
+func (r *ACMEResolver) Resolve(hostname string) (string, error) {
+	r.mu.Lock()
+	cert, exists := r.certs[hostname]
+	r.mu.Unlock()
+	if exists {
+		return cert.path, nil
+	}
+	return r.obtain(hostname)
+}
+
+func (r *ACMEResolver) obtain(hostname string) (string, error) {
+	request := certificate.ObtainRequest{
+		Domains: []string{hostname},
+		Bundle:  true,
+	}
+	resource, err := r.client.Certificate.Obtain(request)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(r.options.StorageDir, hostname+".pem")
+	pemBytes := append(append([]byte{}, resource.Certificate...), resource.PrivateKey...)
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		return "", err
+	}
+
+	notAfter := time.Now().Add(defaultRenewBefore * 3)
+	if block, _ := pem.Decode(resource.Certificate); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			notAfter = cert.NotAfter
+		}
+	}
+
+	r.mu.Lock()
+	r.certs[hostname] = &acmeCertificate{path: path, notAfter: notAfter}
+	r.mu.Unlock()
+	return path, nil
+}
+
+func (r *ACMEResolver) renewalLoop() {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		due := make([]string, 0)
+		for hostname, cert := range r.certs {
+			if time.Until(cert.notAfter) <= r.options.RenewBefore {
+				due = append(due, hostname)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, hostname := range due {
+			if _, err := r.obtain(hostname); err != nil {
+				color.Red("ACME renewal failed for " + hostname + ": " + err.Error())
+				continue
+			}
+			r.configurator.notifyConfigChange()
+		}
+	}
+}
+
+func buildACMEHTTPClient(options ACMEResolverOptions) (*http.Client, error) {
+	var pool *x509.CertPool
+	if options.CASystemCertPool {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		pool = systemPool
+	} else {
+		pool = x509.NewCertPool()
+	}
+
+	for _, path := range options.CACertificates {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("acme: no certificates found in " + path)
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}, nil
+}
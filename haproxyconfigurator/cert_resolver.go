@@ -0,0 +1,32 @@
+package haproxyconfigurator
+
+// CertificateResolver knows how to obtain a TLS certificate for a hostname
+// and return the path to a PEM file (chain + key) that HAProxy can load in
+// a `bind ... ssl crt ...` line. Implementations are expected to cache what
+// they issue and keep it current; Resolve may be called repeatedly for the
+// same hostname as listeners are (re)configured.
+type CertificateResolver interface {
+	Resolve(hostname string) (string, error)
+}
+
+// RegisterResolver makes resolver available to listeners under name. Listeners
+// reference it by setting HaproxyListenerConfig.CertificateResolver.
+func (h *HaproxyConfigurator) RegisterResolver(name string, resolver CertificateResolver) {
+	if h.resolvers == nil {
+		h.resolvers = make(map[string]CertificateResolver)
+	}
+	h.resolvers[name] = resolver
+}
+
+// OnConfigChange registers a callback that is invoked whenever a resolver
+// rotates a certificate out from under an already-rendered listener, so the
+// caller knows to call Render() again and push the result out.
+func (h *HaproxyConfigurator) OnConfigChange(callback func()) {
+	h.onConfigChange = callback
+}
+
+func (h *HaproxyConfigurator) notifyConfigChange() {
+	if h.onConfigChange != nil {
+		h.onConfigChange()
+	}
+}
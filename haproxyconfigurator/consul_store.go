@@ -0,0 +1,90 @@
+package haproxyconfigurator
+
+import (
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// longPollTimeout bounds each blocking query to Consul; Watch immediately
+// re-issues the query afterwards, so this only controls how quickly a lost
+// connection is noticed.
+const longPollTimeout = 5 * time.Minute
+
+// ConsulStore is the KVStore implementation backing KVProvider for
+// Consul. Other backends (etcd, ZooKeeper) can implement the same
+// interface without KVProvider needing to know which one is in use.
+type ConsulStore struct {
+	client *consulapi.Client
+}
+
+// NewConsulStore builds a ConsulStore from a standard consul/api config,
+// e.g. consulapi.DefaultConfig() with Address overridden.
+func NewConsulStore(config *consulapi.Config) (*ConsulStore, error) {
+	client, err := consulapi.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulStore{client: client}, nil
+}
+
+// List returns every key/value pair under prefix.
+func (s *ConsulStore) List(prefix string) (map[string]string, error) {
+	pairs, _, err := s.client.KV().List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toStringMap(pairs), nil
+}
+
+// Watch blocks on Consul's long-poll query mechanism, sending the full set
+// of pairs under prefix on the returned channel every time the index
+// changes, until stopCh is closed.
+func (s *ConsulStore) Watch(prefix string, stopCh <-chan struct{}) (<-chan map[string]string, error) {
+	updates := make(chan map[string]string)
+
+	go func() {
+		defer close(updates)
+		var lastIndex uint64
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+
+			pairs, meta, err := s.client.KV().List(prefix, &consulapi.QueryOptions{
+				WaitIndex: lastIndex,
+				WaitTime:  longPollTimeout,
+			})
+			if err != nil {
+				// Back off briefly before retrying rather than busy-looping
+				// on a Consul outage.
+				time.Sleep(time.Second)
+				continue
+			}
+
+			if meta.LastIndex == lastIndex {
+				continue
+			}
+			lastIndex = meta.LastIndex
+
+			select {
+			case updates <- toStringMap(pairs):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+func toStringMap(pairs consulapi.KVPairs) map[string]string {
+	result := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		result[pair.Key] = string(pair.Value)
+	}
+	return result
+}
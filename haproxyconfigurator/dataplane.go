@@ -0,0 +1,173 @@
+package haproxyconfigurator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// OutputMode selects how a reconciled config reaches haproxy: a full
+// Render() plus reload, or incremental Data Plane API calls driven by
+// Diff(). Render remains the default/fallback; DataPlane is opt-in via a
+// command-line flag in the caller.
+type OutputMode int
+
+const (
+	// RenderOutput writes a full config file and reloads haproxy.
+	RenderOutput OutputMode = iota
+	// DataPlaneOutput pushes only the Changes from Diff() through the
+	// HAProxy Data Plane API, for a hitless update.
+	DataPlaneOutput
+)
+
+// DataPlaneClient talks to a running HAProxy's Data Plane API
+// (https://github.com/haproxytech/dataplaneapi) to apply Changes produced
+// by HaproxyConfigurator.Diff in place.
+type DataPlaneClient struct {
+	BaseURL  string
+	Username string
+	Password string
+	client   *http.Client
+}
+
+// NewDataPlaneClient builds a client for the Data Plane API listening at
+// baseURL, e.g. "http://127.0.0.1:5555".
+func NewDataPlaneClient(baseURL, username, password string) *DataPlaneClient {
+	return &DataPlaneClient{BaseURL: baseURL, Username: username, Password: password, client: &http.Client{}}
+}
+
+// Apply opens a transaction, applies every Change within it, and commits -
+// so a partial failure doesn't leave haproxy in a half-updated state.
+func (d *DataPlaneClient) Apply(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	transactionID, err := d.openTransaction()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if err := d.applyChange(transactionID, change); err != nil {
+			d.deleteTransaction(transactionID)
+			return err
+		}
+	}
+
+	return d.commitTransaction(transactionID)
+}
+
+func (d *DataPlaneClient) openTransaction() (string, error) {
+	version, err := d.configVersion()
+	if err != nil {
+		return "", err
+	}
+
+	var transaction struct {
+		ID string `json:"id"`
+	}
+	path := fmt.Sprintf("/v2/services/haproxy/transactions?version=%d", version)
+	if err := d.do("POST", path, nil, &transaction); err != nil {
+		return "", err
+	}
+	return transaction.ID, nil
+}
+
+// configVersion fetches haproxy's live config version, the optimistic-
+// concurrency token the Data Plane API requires when opening a
+// transaction. It changes on every commit, so it's re-fetched before each
+// Apply rather than assumed to still be 1 after the first one.
+func (d *DataPlaneClient) configVersion() (int, error) {
+	var version int
+	if err := d.do("GET", "/v2/services/haproxy/configuration/version", nil, &version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (d *DataPlaneClient) commitTransaction(id string) error {
+	return d.do("PUT", "/v2/services/haproxy/transactions/"+id, nil, nil)
+}
+
+func (d *DataPlaneClient) deleteTransaction(id string) {
+	_ = d.do("DELETE", "/v2/services/haproxy/transactions/"+id, nil, nil)
+}
+
+func (d *DataPlaneClient) applyChange(transactionID string, change Change) error {
+	query := "?transaction_id=" + transactionID
+	switch change.Type {
+	case ChangeAddBackend:
+		return d.do("POST", "/v2/services/haproxy/configuration/backends"+query, map[string]interface{}{
+			"name":    change.Backend,
+			"balance": map[string]string{"algorithm": balanceAlgorithm(change)},
+		}, nil)
+	case ChangeUpdateBackend:
+		return d.do("PUT", "/v2/services/haproxy/configuration/backends/"+change.Backend+query, map[string]interface{}{
+			"name":    change.Backend,
+			"balance": map[string]string{"algorithm": balanceAlgorithm(change)},
+		}, nil)
+	case ChangeRemoveBackend:
+		return d.do("DELETE", "/v2/services/haproxy/configuration/backends/"+change.Backend+query, nil, nil)
+	case ChangeUpsertServer:
+		server := map[string]interface{}{"name": change.Server, "address": change.IP, "port": change.Port, "check": "enabled"}
+		if change.UseSSL {
+			server["ssl"] = "enabled"
+			if !change.VerifySSL {
+				server["ssl_verify"] = "none"
+			}
+		}
+		serverPath := fmt.Sprintf("/v2/services/haproxy/configuration/backends/%s/servers/%s%s", change.Backend, change.Server, query)
+		if err := d.do("PUT", serverPath, server, nil); err != nil {
+			// No existing server under this name - create it instead.
+			return d.do("POST", fmt.Sprintf("/v2/services/haproxy/configuration/backends/%s/servers%s", change.Backend, query), server, nil)
+		}
+		return nil
+	case ChangeRemoveServer:
+		return d.do("DELETE", fmt.Sprintf("/v2/services/haproxy/configuration/backends/%s/servers/%s%s", change.Backend, change.Server, query), nil, nil)
+	}
+	return nil
+}
+
+// balanceAlgorithm defaults an empty BalanceMethod to "roundrobin", the same
+// default haproxy itself applies, so a Change built from a backend that
+// never set one still produces a valid Data Plane API call.
+func balanceAlgorithm(change Change) string {
+	if change.BalanceMethod == "" {
+		return "roundrobin"
+	}
+	return change.BalanceMethod
+}
+
+func (d *DataPlaneClient) do(method, path string, body interface{}, out interface{}) error {
+	reader := bytes.NewReader(nil)
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, d.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(d.Username, d.Password)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("dataplane api: %s %s returned %d", method, path, resp.StatusCode)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package haproxyconfigurator
+
+// ChangeType identifies the kind of mutation a Change represents.
+type ChangeType int
+
+const (
+	// ChangeAddBackend creates a backend that exists in the new desired
+	// config but not the previous one.
+	ChangeAddBackend ChangeType = iota
+	// ChangeRemoveBackend deletes a backend that no longer appears.
+	ChangeRemoveBackend
+	// ChangeUpdateBackend updates an existing backend's balance algorithm
+	// when its settings (balance algorithm, server SSL) change in place. A
+	// change to the backend's server SSL settings also re-upserts each of
+	// its servers, since SSL is applied per server.
+	ChangeUpdateBackend
+	// ChangeUpsertServer creates or updates a single server within a
+	// backend, e.g. in response to a pod getting rescheduled to a new IP.
+	ChangeUpsertServer
+	// ChangeRemoveServer deletes a server that no longer appears within a
+	// backend that otherwise still exists.
+	ChangeRemoveServer
+)
+
+// Change is one minimal mutation needed to move a running haproxy instance
+// from a previous desired state to the current one. A caller feeds a slice
+// of these into DataPlaneClient.Apply instead of Render()ing a full config
+// and reloading the process. BalanceMethod, UseSSL and VerifySSL mirror the
+// owning HaproxyBackend's settings at the time of the change, since the
+// Data Plane API needs them on every backend/server call, not just the
+// first one.
+type Change struct {
+	Type          ChangeType
+	Backend       string
+	Server        string
+	IP            string
+	Port          uint16
+	BalanceMethod string
+	UseSSL        bool
+	VerifySSL     bool
+}
+
+// Diff compares the configurator's current desired state against previous
+// and returns the Changes needed to reconcile a live haproxy from one to
+// the other - new/removed backends, backend-level settings that changed in
+// place, and within backends still present, servers that were added,
+// moved, or removed, or whose backend's SSL settings changed.
+func (h *HaproxyConfigurator) Diff(previous haproxyConfig) []Change {
+	changes := []Change{}
+
+	previousBackends := collectBackends(previous)
+	currentBackends := collectBackends(h.desiredConfig)
+
+	for name, backend := range currentBackends {
+		previousBackend, existed := previousBackends[name]
+		if !existed {
+			changes = append(changes, Change{Type: ChangeAddBackend, Backend: name, BalanceMethod: backend.BalanceMethod})
+			for _, server := range backend.Backends {
+				changes = append(changes, Change{
+					Type: ChangeUpsertServer, Backend: name, Server: server.Name, IP: server.IP, Port: server.Port,
+					UseSSL: backend.UseSSL, VerifySSL: backend.VerifySSL,
+				})
+			}
+			continue
+		}
+		if previousBackend.BalanceMethod != backend.BalanceMethod || previousBackend.UseSSL != backend.UseSSL || previousBackend.VerifySSL != backend.VerifySSL {
+			changes = append(changes, Change{
+				Type: ChangeUpdateBackend, Backend: name,
+				BalanceMethod: backend.BalanceMethod, UseSSL: backend.UseSSL, VerifySSL: backend.VerifySSL,
+			})
+		}
+		changes = append(changes, diffServers(name, previousBackend, backend)...)
+	}
+
+	for name := range previousBackends {
+		if _, stillExists := currentBackends[name]; !stillExists {
+			changes = append(changes, Change{Type: ChangeRemoveBackend, Backend: name})
+		}
+	}
+
+	return changes
+}
+
+func collectBackends(config haproxyConfig) map[string]*HaproxyBackend {
+	backends := map[string]*HaproxyBackend{}
+	for _, ports := range config.listenIPs {
+		for _, listener := range ports {
+			for _, backend := range listener.hostnameBackends {
+				backends[backend.Name] = backend
+			}
+		}
+	}
+	return backends
+}
+
+func diffServers(name string, previous, current *HaproxyBackend) []Change {
+	changes := []Change{}
+
+	previousServers := map[string]HaproxyBackendServer{}
+	for _, server := range previous.Backends {
+		previousServers[server.Name] = server
+	}
+
+	currentServers := map[string]bool{}
+	for _, server := range current.Backends {
+		currentServers[server.Name] = true
+		existing, existed := previousServers[server.Name]
+		sslChanged := previous.UseSSL != current.UseSSL || previous.VerifySSL != current.VerifySSL
+		if !existed || existing.IP != server.IP || existing.Port != server.Port || sslChanged {
+			changes = append(changes, Change{
+				Type: ChangeUpsertServer, Backend: name, Server: server.Name, IP: server.IP, Port: server.Port,
+				UseSSL: current.UseSSL, VerifySSL: current.VerifySSL,
+			})
+		}
+	}
+
+	for serverName := range previousServers {
+		if !currentServers[serverName] {
+			changes = append(changes, Change{Type: ChangeRemoveServer, Backend: name, Server: serverName})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,596 @@
+package haproxyconfigurator
+
+import (
+	"os"
+	"sync"
+
+	"github.com/fatih/color"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+	gatewayclientset "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+)
+
+// certificateStorageDir is where certificates materialized from Gateway
+// listener TLS secrets are written for use in `bind ... ssl crt ...` lines.
+var certificateStorageDir = "/etc/haproxy-configurator/gateway-certs"
+
+// gatewayModeFor maps a Gateway API listener protocol to the haproxy mode
+// used when rendering the frontend it becomes.
+func gatewayModeFor(protocol gatewayv1beta1.ProtocolType) string {
+	switch protocol {
+	case gatewayv1beta1.HTTPProtocolType, gatewayv1beta1.HTTPSProtocolType:
+		return "http"
+	default:
+		// TCPProtocolType, TLSProtocolType and UDPProtocolType all proxy at
+		// the connection level.
+		return "tcp"
+	}
+}
+
+// getGatewayListeners loads every Gateway in the cluster, along with the
+// HTTPRoutes, TCPRoutes and TLSRoutes attached to them, and turns each
+// listener into one or more HaproxyListenerConfig. This is an alternative
+// to getProxiedKubernetesServices: the two discovery modes can be run side
+// by side, both feeding the same HaproxyConfigurator via AddListener. Each
+// processed Gateway has its status updated to reflect how many routes
+// ended up attached to each of its listeners.
+func getGatewayListeners() ([]HaproxyListenerConfig, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	gatewayClientset, err := gatewayclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	coreClientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	gateways, err := gatewayClientset.GatewayV1beta1().Gateways("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	httpRoutes, err := gatewayClientset.GatewayV1beta1().HTTPRoutes("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	tcpRoutes, err := gatewayClientset.GatewayV1alpha2().TCPRoutes("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	tlsRoutes, err := gatewayClientset.GatewayV1alpha2().TLSRoutes("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	listeners, attachedRoutes, err := buildGatewayListeners(coreClientset, gateways.Items, httpRoutes.Items, tcpRoutes.Items, tlsRoutes.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gateway := range gateways.Items {
+		updateGatewayStatus(gatewayClientset, gateway, attachedRoutes[gateway.Namespace+"/"+gateway.Name])
+	}
+
+	return listeners, nil
+}
+
+// GatewayReconcileFunc is called with the full recomputed set of listeners
+// every time a watched Gateway, HTTPRoute, TCPRoute, or TLSRoute changes.
+// The caller is expected to call HaproxyConfigurator.AddListener for each
+// one, then Render() or Diff() the result.
+type GatewayReconcileFunc func(listeners []HaproxyListenerConfig)
+
+// WatchGatewayListeners replaces the one-shot getGatewayListeners call
+// with a long-running reconciliation loop backed by one informer each
+// (cache.NewInformer) over Gateways, HTTPRoutes, TCPRoutes and TLSRoutes, so
+// route/listener churn is picked up without a poll. These informers belong
+// to this loop alone and are not shared with other watchers. It reports each Gateway's programmed state
+// back the same way getGatewayListeners does, after every recompute. It
+// blocks until stopCh is closed.
+func WatchGatewayListeners(reconcile GatewayReconcileFunc, stopCh <-chan struct{}) error {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	if err != nil {
+		return err
+	}
+	gatewayClientset, err := gatewayclientset.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	coreClientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// mu guards the four caches below: each informer's handlers run on
+	// their own goroutine, and emit reads all four, so unsynchronized
+	// access would race the same way it would in WatchKubernetesServices.
+	var mu sync.Mutex
+	gateways := map[string]gatewayv1beta1.Gateway{}
+	httpRoutes := map[string]gatewayv1beta1.HTTPRoute{}
+	tcpRoutes := map[string]gatewayv1alpha2.TCPRoute{}
+	tlsRoutes := map[string]gatewayv1alpha2.TLSRoute{}
+
+	emit := func() {
+		mu.Lock()
+		gatewayList := make([]gatewayv1beta1.Gateway, 0, len(gateways))
+		for _, gateway := range gateways {
+			gatewayList = append(gatewayList, gateway)
+		}
+		httpRouteList := make([]gatewayv1beta1.HTTPRoute, 0, len(httpRoutes))
+		for _, route := range httpRoutes {
+			httpRouteList = append(httpRouteList, route)
+		}
+		tcpRouteList := make([]gatewayv1alpha2.TCPRoute, 0, len(tcpRoutes))
+		for _, route := range tcpRoutes {
+			tcpRouteList = append(tcpRouteList, route)
+		}
+		tlsRouteList := make([]gatewayv1alpha2.TLSRoute, 0, len(tlsRoutes))
+		for _, route := range tlsRoutes {
+			tlsRouteList = append(tlsRouteList, route)
+		}
+		mu.Unlock()
+
+		listeners, attachedRoutes, err := buildGatewayListeners(coreClientset, gatewayList, httpRouteList, tcpRouteList, tlsRouteList)
+		if err != nil {
+			color.Red("gateway reconcile failed: " + err.Error())
+			return
+		}
+		for _, gateway := range gatewayList {
+			updateGatewayStatus(gatewayClientset, gateway, attachedRoutes[gateway.Namespace+"/"+gateway.Name])
+		}
+		reconcile(listeners)
+	}
+
+	_, gatewayController := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return gatewayClientset.GatewayV1beta1().Gateways("").List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return gatewayClientset.GatewayV1beta1().Gateways("").Watch(options)
+			},
+		},
+		&gatewayv1beta1.Gateway{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { setGatewayCache(&mu, gateways, obj); emit() },
+			UpdateFunc: func(_, obj interface{}) { setGatewayCache(&mu, gateways, obj); emit() },
+			DeleteFunc: func(obj interface{}) { deleteGatewayCache(&mu, gateways, obj); emit() },
+		},
+	)
+
+	_, httpRouteController := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return gatewayClientset.GatewayV1beta1().HTTPRoutes("").List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return gatewayClientset.GatewayV1beta1().HTTPRoutes("").Watch(options)
+			},
+		},
+		&gatewayv1beta1.HTTPRoute{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { setHTTPRouteCache(&mu, httpRoutes, obj); emit() },
+			UpdateFunc: func(_, obj interface{}) { setHTTPRouteCache(&mu, httpRoutes, obj); emit() },
+			DeleteFunc: func(obj interface{}) { deleteHTTPRouteCache(&mu, httpRoutes, obj); emit() },
+		},
+	)
+
+	_, tcpRouteController := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return gatewayClientset.GatewayV1alpha2().TCPRoutes("").List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return gatewayClientset.GatewayV1alpha2().TCPRoutes("").Watch(options)
+			},
+		},
+		&gatewayv1alpha2.TCPRoute{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { setTCPRouteCache(&mu, tcpRoutes, obj); emit() },
+			UpdateFunc: func(_, obj interface{}) { setTCPRouteCache(&mu, tcpRoutes, obj); emit() },
+			DeleteFunc: func(obj interface{}) { deleteTCPRouteCache(&mu, tcpRoutes, obj); emit() },
+		},
+	)
+
+	_, tlsRouteController := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return gatewayClientset.GatewayV1alpha2().TLSRoutes("").List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return gatewayClientset.GatewayV1alpha2().TLSRoutes("").Watch(options)
+			},
+		},
+		&gatewayv1alpha2.TLSRoute{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { setTLSRouteCache(&mu, tlsRoutes, obj); emit() },
+			UpdateFunc: func(_, obj interface{}) { setTLSRouteCache(&mu, tlsRoutes, obj); emit() },
+			DeleteFunc: func(obj interface{}) { deleteTLSRouteCache(&mu, tlsRoutes, obj); emit() },
+		},
+	)
+
+	go gatewayController.Run(stopCh)
+	go httpRouteController.Run(stopCh)
+	go tcpRouteController.Run(stopCh)
+	go tlsRouteController.Run(stopCh)
+	<-stopCh
+	return nil
+}
+
+func setGatewayCache(mu *sync.Mutex, cache map[string]gatewayv1beta1.Gateway, obj interface{}) {
+	gateway, ok := obj.(*gatewayv1beta1.Gateway)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	cache[gateway.Namespace+"/"+gateway.Name] = *gateway
+	mu.Unlock()
+}
+
+func deleteGatewayCache(mu *sync.Mutex, cache map[string]gatewayv1beta1.Gateway, obj interface{}) {
+	gateway, ok := obj.(*gatewayv1beta1.Gateway)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	delete(cache, gateway.Namespace+"/"+gateway.Name)
+	mu.Unlock()
+}
+
+func setHTTPRouteCache(mu *sync.Mutex, cache map[string]gatewayv1beta1.HTTPRoute, obj interface{}) {
+	route, ok := obj.(*gatewayv1beta1.HTTPRoute)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	cache[route.Namespace+"/"+route.Name] = *route
+	mu.Unlock()
+}
+
+func deleteHTTPRouteCache(mu *sync.Mutex, cache map[string]gatewayv1beta1.HTTPRoute, obj interface{}) {
+	route, ok := obj.(*gatewayv1beta1.HTTPRoute)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	delete(cache, route.Namespace+"/"+route.Name)
+	mu.Unlock()
+}
+
+func setTCPRouteCache(mu *sync.Mutex, cache map[string]gatewayv1alpha2.TCPRoute, obj interface{}) {
+	route, ok := obj.(*gatewayv1alpha2.TCPRoute)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	cache[route.Namespace+"/"+route.Name] = *route
+	mu.Unlock()
+}
+
+func deleteTCPRouteCache(mu *sync.Mutex, cache map[string]gatewayv1alpha2.TCPRoute, obj interface{}) {
+	route, ok := obj.(*gatewayv1alpha2.TCPRoute)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	delete(cache, route.Namespace+"/"+route.Name)
+	mu.Unlock()
+}
+
+func setTLSRouteCache(mu *sync.Mutex, cache map[string]gatewayv1alpha2.TLSRoute, obj interface{}) {
+	route, ok := obj.(*gatewayv1alpha2.TLSRoute)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	cache[route.Namespace+"/"+route.Name] = *route
+	mu.Unlock()
+}
+
+func deleteTLSRouteCache(mu *sync.Mutex, cache map[string]gatewayv1alpha2.TLSRoute, obj interface{}) {
+	route, ok := obj.(*gatewayv1alpha2.TLSRoute)
+	if !ok {
+		return
+	}
+	mu.Lock()
+	delete(cache, route.Namespace+"/"+route.Name)
+	mu.Unlock()
+}
+
+// buildGatewayListeners turns a set of Gateways plus the HTTPRoutes,
+// TCPRoutes and TLSRoutes attached to them into HaproxyListenerConfigs. It
+// also returns, per Gateway ("namespace/name"), the number of routes that
+// ended up attached to each of that Gateway's listeners by name, for
+// updateGatewayStatus to report back.
+func buildGatewayListeners(
+	coreClientset *kubernetes.Clientset,
+	gateways []gatewayv1beta1.Gateway,
+	httpRoutes []gatewayv1beta1.HTTPRoute,
+	tcpRoutes []gatewayv1alpha2.TCPRoute,
+	tlsRoutes []gatewayv1alpha2.TLSRoute,
+) ([]HaproxyListenerConfig, map[string]map[string]int32, error) {
+	listeners := []HaproxyListenerConfig{}
+	attachedRoutes := map[string]map[string]int32{}
+
+	for _, gateway := range gateways {
+		gatewayKey := gateway.Namespace + "/" + gateway.Name
+		attachedRoutes[gatewayKey] = map[string]int32{}
+
+		for _, listener := range gateway.Spec.Listeners {
+			hlc := HaproxyListenerConfig{
+				Name:       gateway.Namespace + "-" + gateway.Name + "-" + string(listener.Name),
+				ListenIP:   "0.0.0.0",
+				ListenPort: uint16(listener.Port),
+				Mode:       gatewayModeFor(listener.Protocol),
+				Backend: HaproxyBackend{
+					Name:          gateway.Namespace + "-" + gateway.Name + "-" + string(listener.Name),
+					BalanceMethod: "roundrobin",
+				},
+			}
+
+			if listener.TLS != nil && len(listener.TLS.CertificateRefs) > 0 {
+				certPath, err := materializeSecretCertificate(coreClientset, gateway.Namespace, listener.TLS.CertificateRefs[0])
+				if err != nil {
+					return nil, nil, err
+				}
+				hlc.SslCertificate = certPath
+			}
+
+			attached := int32(0)
+
+			for _, route := range httpRoutes {
+				if !routeAttachedTo(route.Spec.ParentRefs, gateway.Name, string(listener.Name)) {
+					continue
+				}
+				if len(route.Spec.Hostnames) == 0 {
+					routeListener := hlc
+					routeListener.Backend = backendForRoute(route)
+					listeners = append(listeners, routeListener)
+					attached++
+					continue
+				}
+				for _, hostname := range route.Spec.Hostnames {
+					routeListener := hlc
+					routeListener.Hostname = string(hostname)
+					routeListener.Backend = backendForRoute(route)
+					listeners = append(listeners, routeListener)
+				}
+				attached++
+			}
+
+			// AddListener panics on a second tcp listener bound to the same
+			// (ListenIP, ListenPort), and tcp mode only ever renders a
+			// single default_backend - there's no per-SNI routing here. So
+			// every TCPRoute/TLSRoute attached to this Gateway listener, and
+			// every hostname on a TLSRoute, folds into one merged backend
+			// and a single AddListener call instead of one per route.
+			tcpBackend := HaproxyBackend{
+				Name:          hlc.Backend.Name,
+				BalanceMethod: "roundrobin",
+			}
+			tcpAttached := int32(0)
+			for _, route := range tcpRoutes {
+				if !alpha2RouteAttachedTo(route.Spec.ParentRefs, gateway.Name, string(listener.Name)) {
+					continue
+				}
+				tcpBackend.Backends = append(tcpBackend.Backends, backendForTCPRoute(route).Backends...)
+				tcpAttached++
+			}
+			for _, route := range tlsRoutes {
+				if !alpha2RouteAttachedTo(route.Spec.ParentRefs, gateway.Name, string(listener.Name)) {
+					continue
+				}
+				tcpBackend.Backends = append(tcpBackend.Backends, backendForTLSRoute(route).Backends...)
+				tcpAttached++
+			}
+			if tcpAttached > 0 {
+				routeListener := hlc
+				routeListener.Backend = tcpBackend
+				listeners = append(listeners, routeListener)
+				attached += tcpAttached
+			}
+
+			attachedRoutes[gatewayKey][string(listener.Name)] = attached
+			if attached == 0 {
+				listeners = append(listeners, hlc)
+			}
+		}
+	}
+
+	return listeners, attachedRoutes, nil
+}
+
+// updateGatewayStatus reports the outcome of buildGatewayListeners back to
+// gateway - the per-listener attached route count and a "Programmed"
+// condition on the Gateway and each of its listeners - the same way a
+// Gateway API controller such as istio or contour would after reconciling
+// it, so `kubectl get gateway` reflects reality instead of staying
+// permanently "Unknown".
+func updateGatewayStatus(gatewayClientset gatewayclientset.Interface, gateway gatewayv1beta1.Gateway, attachedRoutes map[string]int32) {
+	now := metav1.Now()
+
+	listenerStatuses := make([]gatewayv1beta1.ListenerStatus, 0, len(gateway.Spec.Listeners))
+	for _, listener := range gateway.Spec.Listeners {
+		listenerStatuses = append(listenerStatuses, gatewayv1beta1.ListenerStatus{
+			Name:           listener.Name,
+			SupportedKinds: []gatewayv1beta1.RouteGroupKind{},
+			AttachedRoutes: attachedRoutes[string(listener.Name)],
+			Conditions: []metav1.Condition{{
+				Type:               string(gatewayv1beta1.ListenerConditionProgrammed),
+				Status:             metav1.ConditionTrue,
+				Reason:             string(gatewayv1beta1.ListenerReasonProgrammed),
+				Message:            "Programmed by haproxyconfigurator",
+				ObservedGeneration: gateway.Generation,
+				LastTransitionTime: now,
+			}},
+		})
+	}
+
+	gateway.Status.Listeners = listenerStatuses
+	gateway.Status.Conditions = []metav1.Condition{{
+		Type:               string(gatewayv1beta1.GatewayConditionProgrammed),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1beta1.GatewayReasonProgrammed),
+		Message:            "Programmed by haproxyconfigurator",
+		ObservedGeneration: gateway.Generation,
+		LastTransitionTime: now,
+	}}
+
+	if _, err := gatewayClientset.GatewayV1beta1().Gateways(gateway.Namespace).UpdateStatus(&gateway); err != nil {
+		color.Red("failed to update status for gateway " + gateway.Namespace + "/" + gateway.Name + ": " + err.Error())
+	}
+}
+
+func routeAttachedTo(parentRefs []gatewayv1beta1.ParentReference, gatewayName, listenerName string) bool {
+	for _, ref := range parentRefs {
+		if string(ref.Name) != gatewayName {
+			continue
+		}
+		if ref.SectionName == nil || string(*ref.SectionName) == listenerName {
+			return true
+		}
+	}
+	return false
+}
+
+// alpha2RouteAttachedTo is routeAttachedTo for the v1alpha2 ParentReference
+// type used by TCPRoute and TLSRoute - the two types are structurally
+// identical to v1beta1's, but Go doesn't let a single function accept both.
+func alpha2RouteAttachedTo(parentRefs []gatewayv1alpha2.ParentReference, gatewayName, listenerName string) bool {
+	for _, ref := range parentRefs {
+		if string(ref.Name) != gatewayName {
+			continue
+		}
+		if ref.SectionName == nil || string(*ref.SectionName) == listenerName {
+			return true
+		}
+	}
+	return false
+}
+
+// backendForRoute builds the HaproxyBackend for a single HTTPRoute, one
+// server per weighted backendRef across its rules.
+func backendForRoute(route gatewayv1beta1.HTTPRoute) HaproxyBackend {
+	backend := HaproxyBackend{
+		Name:          route.Namespace + "-" + route.Name,
+		BalanceMethod: "roundrobin",
+	}
+	for _, rule := range route.Spec.Rules {
+		for _, backendRef := range rule.BackendRefs {
+			port := uint16(80)
+			if backendRef.Port != nil {
+				port = uint16(*backendRef.Port)
+			}
+			weight := int32(1)
+			if backendRef.Weight != nil {
+				weight = *backendRef.Weight
+			}
+			backend.Backends = append(backend.Backends, HaproxyBackendServer{
+				Name:   string(backendRef.Name),
+				IP:     string(backendRef.Name) + "." + route.Namespace + ".svc.cluster.local",
+				Port:   port,
+				Weight: weight,
+			})
+		}
+	}
+	return backend
+}
+
+// backendForTCPRoute builds the HaproxyBackend for a single TCPRoute, one
+// server per weighted backendRef across its rules - the connection-level
+// equivalent of backendForRoute.
+func backendForTCPRoute(route gatewayv1alpha2.TCPRoute) HaproxyBackend {
+	backend := HaproxyBackend{
+		Name:          route.Namespace + "-" + route.Name,
+		BalanceMethod: "roundrobin",
+	}
+	for _, rule := range route.Spec.Rules {
+		for _, backendRef := range rule.BackendRefs {
+			port := uint16(80)
+			if backendRef.Port != nil {
+				port = uint16(*backendRef.Port)
+			}
+			weight := int32(1)
+			if backendRef.Weight != nil {
+				weight = *backendRef.Weight
+			}
+			backend.Backends = append(backend.Backends, HaproxyBackendServer{
+				Name:   string(backendRef.Name),
+				IP:     string(backendRef.Name) + "." + route.Namespace + ".svc.cluster.local",
+				Port:   port,
+				Weight: weight,
+			})
+		}
+	}
+	return backend
+}
+
+// backendForTLSRoute builds the HaproxyBackend for a single TLSRoute, the
+// same way backendForTCPRoute does for a TCPRoute. Its Hostnames aren't
+// used for SNI routing - buildGatewayListeners merges every route on a tcp
+// Gateway listener into one backend, since haproxy tcp mode here only ever
+// dispatches to a single default_backend.
+func backendForTLSRoute(route gatewayv1alpha2.TLSRoute) HaproxyBackend {
+	backend := HaproxyBackend{
+		Name:          route.Namespace + "-" + route.Name,
+		BalanceMethod: "roundrobin",
+	}
+	for _, rule := range route.Spec.Rules {
+		for _, backendRef := range rule.BackendRefs {
+			port := uint16(80)
+			if backendRef.Port != nil {
+				port = uint16(*backendRef.Port)
+			}
+			weight := int32(1)
+			if backendRef.Weight != nil {
+				weight = *backendRef.Weight
+			}
+			backend.Backends = append(backend.Backends, HaproxyBackendServer{
+				Name:   string(backendRef.Name),
+				IP:     string(backendRef.Name) + "." + route.Namespace + ".svc.cluster.local",
+				Port:   port,
+				Weight: weight,
+			})
+		}
+	}
+	return backend
+}
+
+// materializeSecretCertificate writes the PEM payload of a Gateway
+// listener's referenced TLS Secret to disk and returns the path, the same
+// way a user-provided SslCertificate is supplied to AddListener.
+func materializeSecretCertificate(clientset *kubernetes.Clientset, namespace string, ref gatewayv1beta1.SecretObjectReference) (string, error) {
+	secretNamespace := namespace
+	if ref.Namespace != nil {
+		secretNamespace = string(*ref.Namespace)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(secretNamespace).Get(string(ref.Name), metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(certificateStorageDir, 0o700); err != nil {
+		return "", err
+	}
+	path := certificateStorageDir + "/" + secretNamespace + "-" + string(ref.Name) + ".pem"
+	pem := append(append([]byte{}, secret.Data["tls.crt"]...), secret.Data["tls.key"]...)
+	if err := os.WriteFile(path, pem, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
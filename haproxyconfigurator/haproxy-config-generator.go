@@ -9,7 +9,9 @@ import (
 
 // HaproxyConfigurator provides an interface to dynamically generate haproxy configs
 type HaproxyConfigurator struct {
-	desiredConfig haproxyConfig
+	desiredConfig  haproxyConfig
+	resolvers      map[string]CertificateResolver
+	onConfigChange func()
 }
 
 // Initialize sets up a new HaproxyConfigurator
@@ -19,13 +21,25 @@ func (h *HaproxyConfigurator) Initialize() {
 
 // HaproxyListenerConfig structure provides configuration options
 type HaproxyListenerConfig struct {
-	Name             string
-	Backend          HaproxyBackend
-	Hostname         string
-	ListenIP         string
-	ListenPort       uint16
-	Mode             string
-	SslCertificate   string
+	Name           string
+	Backend        HaproxyBackend
+	Hostname       string
+	ListenIP       string
+	ListenPort     uint16
+	Mode           string
+	SslCertificate string
+	// CertificateResolver names a resolver previously registered with
+	// HaproxyConfigurator.RegisterResolver. When SslCertificate is empty and
+	// this is set, AddListener asks the resolver to provision one instead of
+	// requiring a pre-existing PEM.
+	CertificateResolver string
+	// WebSocket marks this listener's backend as serving long-lived
+	// WebSocket/HTTP upgrade connections, so Render() emits the ACL and
+	// tunnel timeout needed to keep them alive through haproxy. It sets
+	// Backend.WebSocket as a default; set that directly on a per-hostname
+	// basis instead to override it for just one backend on a shared
+	// listener.
+	WebSocket        bool
 	validationErrors []string
 }
 
@@ -67,6 +81,23 @@ func (hlc *HaproxyListenerConfig) validate(h *HaproxyConfigurator) bool {
 func (h *HaproxyConfigurator) AddListener(
 	hlc HaproxyListenerConfig,
 ) {
+	if hlc.SslCertificate == "" && hlc.CertificateResolver != "" {
+		resolver, exists := h.resolvers[hlc.CertificateResolver]
+		if !exists {
+			color.Red(hlc.Name)
+			color.Red("  Certificate resolver (" + hlc.CertificateResolver + ") is not registered")
+			return
+		}
+
+		path, err := resolver.Resolve(hlc.Hostname)
+		if err != nil {
+			color.Red(hlc.Name)
+			color.Red("  Certificate resolver (" + hlc.CertificateResolver + ") failed: " + err.Error())
+			return
+		}
+		hlc.SslCertificate = path
+	}
+
 	if hlc.validate(h) {
 		if _, exists := h.desiredConfig.listenIPs[hlc.ListenIP]; !exists {
 			h.desiredConfig.listenIPs[hlc.ListenIP] = make(map[uint16]*haproxyListener)
@@ -96,6 +127,9 @@ func (h *HaproxyConfigurator) AddListener(
 		if hlc.Mode == "tcp" {
 			hlc.Hostname = "_"
 		}
+		if hlc.WebSocket {
+			hlc.Backend.WebSocket = true
+		}
 		h.desiredConfig.listenIPs[hlc.ListenIP][hlc.ListenPort].hostnameBackends[hlc.Hostname] = &hlc.Backend
 	} else {
 		color.Red(hlc.Name)
@@ -159,7 +193,24 @@ func (h *HaproxyConfigurator) Render() string {
 			if listener.mode == "http" {
 				for _, hostname := range sortBackendMap(listener.hostnameBackends) {
 					backend := listener.hostnameBackends[hostname]
+					// A hostname-less entry means "match any host" - an
+					// empty hdr(host) ACL pattern isn't valid haproxy
+					// syntax, so it gets the frontend's default_backend
+					// instead of a host ACL.
+					if hostname == "" {
+						config += "    # Set up default_backend for hostname-less route\n"
+						if backend.WebSocket {
+							config += "    acl is_websocket_" + backend.Name + " hdr(Upgrade) -i WebSocket\n"
+							config += "    use_backend " + backend.Name + "_ws if is_websocket_" + backend.Name + "\n"
+						}
+						config += "    default_backend " + backend.Name + "\n"
+						continue
+					}
 					config += "    # Set up backend selection for " + hostname + "\n"
+					if backend.WebSocket {
+						config += "    acl is_websocket_" + backend.Name + " hdr(Upgrade) -i WebSocket\n"
+						config += "    use_backend " + backend.Name + "_ws if is_websocket_" + backend.Name + " { hdr(host) -i " + hostname + " }\n"
+					}
 					config += "    use_backend " + backend.Name + " if { hdr(host) -i " + hostname + " }\n"
 					config += "    use_backend " + backend.Name + " if { hdr(host) -i " + hostname + ":" + strconv.Itoa(int(port)) + " }\n"
 				}
@@ -187,6 +238,9 @@ func (h *HaproxyConfigurator) Render() string {
 				for _, backendServer := range backend.Backends {
 					config += "    server " + backendServer.Name + " " + backendServer.IP + ":" + strconv.Itoa(int(backendServer.Port))
 					config += " check"
+					if backendServer.Weight != 0 {
+						config += " weight " + strconv.Itoa(int(backendServer.Weight))
+					}
 					if backend.UseSSL {
 						config += " ssl"
 						if !backend.VerifySSL {
@@ -196,9 +250,42 @@ func (h *HaproxyConfigurator) Render() string {
 					config += "\n"
 				}
 				config += "\n"
+
+				if backend.WebSocket {
+					config += renderWebSocketBackend(backend, listener.mode)
+				}
 			}
 		}
 	}
 
 	return config
 }
+
+// renderWebSocketBackend builds the dedicated "<name>_ws" backend that
+// upgraded connections are routed to, with a long tunnel timeout so an
+// open WebSocket isn't cut off by the regular server timeout.
+func renderWebSocketBackend(backend *HaproxyBackend, mode string) string {
+	config := "backend " + backend.Name + "_ws\n"
+	config += "    mode " + mode + "\n"
+	config += "    balance " + backend.BalanceMethod + "\n"
+	config += "    option http-server-close\n"
+	config += "    timeout tunnel 1h\n"
+	config += "\n"
+	config += "    # Backend Servers\n"
+	for _, backendServer := range backend.Backends {
+		config += "    server " + backendServer.Name + " " + backendServer.IP + ":" + strconv.Itoa(int(backendServer.Port))
+		config += " check"
+		if backendServer.Weight != 0 {
+			config += " weight " + strconv.Itoa(int(backendServer.Weight))
+		}
+		if backend.UseSSL {
+			config += " ssl"
+			if !backend.VerifySSL {
+				config += " verify none"
+			}
+		}
+		config += "\n"
+	}
+	config += "\n"
+	return config
+}
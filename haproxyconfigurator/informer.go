@@ -0,0 +1,157 @@
+package haproxyconfigurator
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// resyncPeriod controls how often the informers do a full relist against
+// the API server, on top of watching individual change events.
+const resyncPeriod = 10 * time.Minute
+
+// ReconcileFunc is called with the full current set of nodes and proxied
+// services every time either changes. The caller is expected to turn each
+// service into listeners with ListenersFromService, call
+// HaproxyConfigurator.AddListener, then Render() or Diff() the result.
+type ReconcileFunc func(nodes kubernetesNodeIPs, services []v1.Service)
+
+// WatchKubernetesServices replaces the one-shot getAllKubernetesNodes and
+// getProxiedKubernetesServices calls with a long-running reconciliation
+// loop backed by one informer each (cache.NewInformer) over Nodes and
+// Services, so pod/node/service churn is picked up without a poll. These
+// informers belong to this loop alone and are not shared with other
+// watchers. options selects which Services are watched, the same way
+// it does for getProxiedKubernetesServices - LabelSelector defaults to
+// "service-router.enabled=yes" when empty. It blocks until stopCh is
+// closed.
+func WatchKubernetesServices(options KubernetesProviderOptions, reconcile ReconcileFunc, stopCh <-chan struct{}) error {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoKubeconfig, err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoKubeconfig, err)
+	}
+
+	labelSelector := options.LabelSelector
+	if labelSelector == "" {
+		labelSelector = "service-router.enabled=yes"
+	}
+
+	nodeIPs := kubernetesNodeIPs{}
+	services := map[string]v1.Service{}
+	// mu guards nodeIPs and services: the node and service controllers run
+	// their handlers concurrently on their own goroutines, and emit reads
+	// both maps, so unsynchronized access would race.
+	var mu sync.Mutex
+
+	// emit snapshots the shared state under mu, then calls reconcile with
+	// the copies so the lock isn't held for the duration of the caller's
+	// work. services is already restricted to labelSelector/FieldSelector
+	// server-side, matching getProxiedKubernetesServices, so no further
+	// filtering happens here.
+	emit := func() {
+		mu.Lock()
+		nodeIPsCopy := make(kubernetesNodeIPs, len(nodeIPs))
+		for name, ip := range nodeIPs {
+			nodeIPsCopy[name] = ip
+		}
+		proxied := make([]v1.Service, 0, len(services))
+		for _, service := range services {
+			proxied = append(proxied, service)
+		}
+		mu.Unlock()
+		reconcile(nodeIPsCopy, proxied)
+	}
+
+	_, nodeController := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return clientset.CoreV1().Nodes().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return clientset.CoreV1().Nodes().Watch(options)
+			},
+		},
+		&v1.Node{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { mu.Lock(); updateNodeIPs(nodeIPs, obj); mu.Unlock(); emit() },
+			UpdateFunc: func(_, obj interface{}) { mu.Lock(); updateNodeIPs(nodeIPs, obj); mu.Unlock(); emit() },
+			DeleteFunc: func(obj interface{}) { mu.Lock(); removeNodeIPs(nodeIPs, obj); mu.Unlock(); emit() },
+		},
+	)
+
+	_, serviceController := cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(listOptions metav1.ListOptions) (runtime.Object, error) {
+				listOptions.LabelSelector = labelSelector
+				listOptions.FieldSelector = options.FieldSelector
+				return clientset.CoreV1().Services("").List(listOptions)
+			},
+			WatchFunc: func(listOptions metav1.ListOptions) (watch.Interface, error) {
+				listOptions.LabelSelector = labelSelector
+				listOptions.FieldSelector = options.FieldSelector
+				return clientset.CoreV1().Services("").Watch(listOptions)
+			},
+		},
+		&v1.Service{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { mu.Lock(); updateService(services, obj); mu.Unlock(); emit() },
+			UpdateFunc: func(_, obj interface{}) { mu.Lock(); updateService(services, obj); mu.Unlock(); emit() },
+			DeleteFunc: func(obj interface{}) { mu.Lock(); removeService(services, obj); mu.Unlock(); emit() },
+		},
+	)
+
+	go nodeController.Run(stopCh)
+	go serviceController.Run(stopCh)
+	<-stopCh
+	return nil
+}
+
+func updateNodeIPs(nodeIPs kubernetesNodeIPs, obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == "InternalIP" {
+			nodeIPs[node.Name] = address.Address
+		}
+	}
+}
+
+func removeNodeIPs(nodeIPs kubernetesNodeIPs, obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return
+	}
+	delete(nodeIPs, node.Name)
+}
+
+func updateService(services map[string]v1.Service, obj interface{}) {
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	services[service.Namespace+"/"+service.Name] = *service
+}
+
+func removeService(services map[string]v1.Service, obj interface{}) {
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	delete(services, service.Namespace+"/"+service.Name)
+}
@@ -1,7 +1,13 @@
 package haproxyconfigurator
 
 import (
-	"k8s.io/api/core/v1"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -9,22 +15,73 @@ import (
 
 type kubernetesNodeIPs map[string]string
 
+var (
+	// ErrNoKubeconfig is returned when the configured kubeconfig couldn't
+	// be loaded into a client-go rest.Config.
+	ErrNoKubeconfig = errors.New("haproxyconfigurator: unable to load kubeconfig")
+	// ErrListNodes is returned when the Kubernetes API rejects a List call
+	// against Nodes or Services.
+	ErrListNodes = errors.New("haproxyconfigurator: unable to list from kubernetes api")
+	// ErrInvalidAnnotation is returned when a service-router.* annotation
+	// can't be parsed into the value its key expects.
+	ErrInvalidAnnotation = errors.New("haproxyconfigurator: invalid service-router annotation")
+)
+
+// annotationParseFailures counts service-router.* annotations that failed
+// to parse, per Service, so a misconfigured annotation shows up in
+// monitoring instead of only in logs.
+var annotationParseFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "haproxy_configurator_annotation_parse_failures_total",
+		Help: "Count of service-router.* annotations that failed to parse, by service.",
+	},
+	[]string{"namespace", "service"},
+)
+
+func init() {
+	prometheus.MustRegister(annotationParseFailures)
+}
+
+// service-router.* annotation keys. listen-port accepts a comma-separated
+// list so a single Service can produce multiple listeners; the other keys
+// are matched up with it by index.
+const (
+	annotationListenIP   = "service-router.listen-ip"
+	annotationListenPort = "service-router.listen-port"
+	annotationMode       = "service-router.mode"
+	annotationHostname   = "service-router.hostname"
+	annotationSSLSecret  = "service-router.ssl-secret"
+)
+
+// KubernetesProviderOptions configures the selector used by
+// getProxiedKubernetesServices, replacing the old hard-coded
+// "service-router.enabled=yes" label match.
+type KubernetesProviderOptions struct {
+	// LabelSelector is a full labels.Selector string, e.g.
+	// "service-router.enabled=yes,tier!=internal". Defaults to
+	// "service-router.enabled=yes" when empty.
+	LabelSelector string
+	// FieldSelector is an optional field selector string, as accepted by
+	// the Kubernetes API's fieldSelector query parameter.
+	FieldSelector string
+}
+
 // getAllKubernetesNodes loads the nodes in the target kubernetes cluster
 func getAllKubernetesNodes() (kubernetesNodeIPs, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrNoKubeconfig, err)
 	}
 
 	nodeIPs := kubernetesNodeIPs{}
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrNoKubeconfig, err)
 	}
 
 	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
 	if err != nil {
-		logger.Error(err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrListNodes, err)
 	}
 	for _, node := range nodes.Items {
 		for _, address := range node.Status.Addresses {
@@ -36,28 +93,81 @@ func getAllKubernetesNodes() (kubernetesNodeIPs, error) {
 	return nodeIPs, nil
 }
 
-func getProxiedKubernetesServices() ([]v1.Service, error) {
-	// use the current context in kubeconfig
+// getProxiedKubernetesServices lists Services matching options, defaulting
+// to the legacy "service-router.enabled=yes" label when no LabelSelector is
+// given.
+func getProxiedKubernetesServices(options KubernetesProviderOptions) ([]v1.Service, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
 	if err != nil {
-		panic(err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrNoKubeconfig, err)
 	}
 
-	// create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrNoKubeconfig, err)
+	}
+
+	listOptions := metav1.ListOptions{
+		LabelSelector: options.LabelSelector,
+		FieldSelector: options.FieldSelector,
+	}
+	if listOptions.LabelSelector == "" {
+		listOptions.LabelSelector = "service-router.enabled=yes"
 	}
 
-	proxiedServices := []v1.Service{}
-	services, err := clientset.CoreV1().Services("").List(metav1.ListOptions{})
+	services, err := clientset.CoreV1().Services("").List(listOptions)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrListNodes, err)
 	}
-	for _, service := range services.Items {
-		if service.Labels["service-router.enabled"] == "yes" {
-			proxiedServices = append(proxiedServices, service)
+	return services.Items, nil
+}
+
+// ListenersFromService turns one Service's service-router.* annotations
+// into zero or more HaproxyListenerConfig values, letting a single Service
+// produce multiple listeners (e.g. plaintext on one port, TLS on another).
+// A Service with no listen-port annotation produces no listeners.
+func ListenersFromService(service v1.Service) ([]HaproxyListenerConfig, error) {
+	ports := splitAnnotation(service.Annotations[annotationListenPort])
+	if len(ports) == 0 {
+		return nil, nil
+	}
+
+	ips := splitAnnotation(service.Annotations[annotationListenIP])
+	modes := splitAnnotation(service.Annotations[annotationMode])
+	hostnames := splitAnnotation(service.Annotations[annotationHostname])
+	sslSecrets := splitAnnotation(service.Annotations[annotationSSLSecret])
+
+	listeners := make([]HaproxyListenerConfig, 0, len(ports))
+	for i, rawPort := range ports {
+		port, err := strconv.ParseUint(strings.TrimSpace(rawPort), 10, 16)
+		if err != nil {
+			annotationParseFailures.WithLabelValues(service.Namespace, service.Name).Inc()
+			return nil, fmt.Errorf("%w: %s=%q on %s/%s", ErrInvalidAnnotation, annotationListenPort, rawPort, service.Namespace, service.Name)
 		}
+
+		listeners = append(listeners, HaproxyListenerConfig{
+			Name:           service.Namespace + "-" + service.Name + "-" + rawPort,
+			ListenPort:     uint16(port),
+			ListenIP:       annotationAt(ips, i, "0.0.0.0"),
+			Mode:           annotationAt(modes, i, "http"),
+			Hostname:       annotationAt(hostnames, i, ""),
+			SslCertificate: annotationAt(sslSecrets, i, ""),
+		})
+	}
+
+	return listeners, nil
+}
+
+func splitAnnotation(value string) []string {
+	if value == "" {
+		return nil
 	}
-	return proxiedServices, nil
-}
\ No newline at end of file
+	return strings.Split(value, ",")
+}
+
+func annotationAt(values []string, index int, fallback string) string {
+	if index >= len(values) {
+		return fallback
+	}
+	return strings.TrimSpace(values[index])
+}
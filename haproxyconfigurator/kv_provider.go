@@ -0,0 +1,157 @@
+package haproxyconfigurator
+
+import (
+	"strconv"
+	"strings"
+)
+
+// KVStore is the minimal interface a pluggable key/value backend must
+// implement, modeled on libkv's Store so Consul, etcd, or ZooKeeper can be
+// swapped in without touching the provider logic below.
+type KVStore interface {
+	// List returns every key/value pair under prefix.
+	List(prefix string) (map[string]string, error)
+	// Watch long-polls prefix and sends the full current set of pairs
+	// under it on every change, until stopCh is closed.
+	Watch(prefix string, stopCh <-chan struct{}) (<-chan map[string]string, error)
+}
+
+// KVProvider drives a HaproxyConfigurator from a KV store laid out like:
+//
+//	haproxy/frontends/<name>/backend
+//	haproxy/frontends/<name>/mode
+//	haproxy/frontends/<name>/listen_ip
+//	haproxy/frontends/<name>/listen_port
+//	haproxy/frontends/<name>/hostname
+//	haproxy/frontends/<name>/ssl_certificate
+//	haproxy/backends/<name>/balance
+//	haproxy/backends/<name>/servers/<id>/ip
+//	haproxy/backends/<name>/servers/<id>/port
+//	haproxy/backends/<name>/servers/<id>/check
+//	haproxy/backends/<name>/servers/<id>/ssl
+//
+// This mirrors Traefik's KV provider layout, so operators already running
+// Consul/etcd/ZooKeeper for Traefik can reuse the same tree to drive this
+// router, and compose it with other providers feeding the same
+// HaproxyConfigurator. The servers/<id>/check key is not read: every server
+// is rendered with a health check.
+type KVProvider struct {
+	Store  KVStore
+	Prefix string
+}
+
+// NewKVProvider returns a KVProvider rooted at prefix (default "haproxy")
+// reading from store.
+func NewKVProvider(store KVStore, prefix string) *KVProvider {
+	if prefix == "" {
+		prefix = "haproxy"
+	}
+	return &KVProvider{Store: store, Prefix: prefix}
+}
+
+// Listeners reads the current tree and builds one HaproxyListenerConfig per
+// frontend key, with its backend's servers populated from the matching
+// haproxy/backends/<name> subtree.
+func (p *KVProvider) Listeners() ([]HaproxyListenerConfig, error) {
+	pairs, err := p.Store.List(p.Prefix + "/")
+	if err != nil {
+		return nil, err
+	}
+	return buildListenersFromKV(p.Prefix, pairs), nil
+}
+
+// Watch long-polls the store for changes under Prefix and calls reconcile
+// with the freshly rebuilt listener set every time something changes. It
+// blocks until stopCh is closed.
+func (p *KVProvider) Watch(reconcile func([]HaproxyListenerConfig), stopCh <-chan struct{}) error {
+	updates, err := p.Store.Watch(p.Prefix+"/", stopCh)
+	if err != nil {
+		return err
+	}
+	for pairs := range updates {
+		reconcile(buildListenersFromKV(p.Prefix, pairs))
+	}
+	return nil
+}
+
+func buildListenersFromKV(prefix string, pairs map[string]string) []HaproxyListenerConfig {
+	frontends := map[string]map[string]string{}
+	backendFields := map[string]map[string]string{}
+	backendServers := map[string]map[string]map[string]string{}
+
+	for key, value := range pairs {
+		trimmed := strings.TrimPrefix(strings.Trim(key, "/"), strings.Trim(prefix, "/")+"/")
+		parts := strings.Split(trimmed, "/")
+
+		switch {
+		case len(parts) == 3 && parts[0] == "frontends":
+			name, field := parts[1], parts[2]
+			if frontends[name] == nil {
+				frontends[name] = map[string]string{}
+			}
+			frontends[name][field] = value
+		case len(parts) == 3 && parts[0] == "backends" && parts[2] == "balance":
+			name := parts[1]
+			if backendFields[name] == nil {
+				backendFields[name] = map[string]string{}
+			}
+			backendFields[name]["balance"] = value
+		case len(parts) == 5 && parts[0] == "backends" && parts[2] == "servers":
+			name, id, field := parts[1], parts[3], parts[4]
+			if backendServers[name] == nil {
+				backendServers[name] = map[string]map[string]string{}
+			}
+			if backendServers[name][id] == nil {
+				backendServers[name][id] = map[string]string{}
+			}
+			backendServers[name][id][field] = value
+		}
+	}
+
+	listeners := make([]HaproxyListenerConfig, 0, len(frontends))
+	for name, fields := range frontends {
+		backendName := fields["backend"]
+		port, _ := strconv.ParseUint(fields["listen_port"], 10, 16)
+
+		hlc := HaproxyListenerConfig{
+			Name:           name,
+			Hostname:       fields["hostname"],
+			ListenIP:       fields["listen_ip"],
+			ListenPort:     uint16(port),
+			Mode:           fields["mode"],
+			SslCertificate: fields["ssl_certificate"],
+			Backend:        backendFromKV(backendName, backendFields[backendName], backendServers[backendName]),
+		}
+		listeners = append(listeners, hlc)
+	}
+
+	return listeners
+}
+
+func backendFromKV(name string, fields map[string]string, servers map[string]map[string]string) HaproxyBackend {
+	backend := HaproxyBackend{
+		Name:          name,
+		BalanceMethod: fields["balance"],
+	}
+	if backend.BalanceMethod == "" {
+		backend.BalanceMethod = "roundrobin"
+	}
+
+	for id, server := range servers {
+		port, _ := strconv.ParseUint(server["port"], 10, 16)
+		// ssl is documented per-server, but HaproxyBackend only carries SSL
+		// at the backend level (Render applies that one flag to every
+		// server line) - so any server opting in turns SSL on for the whole
+		// backend, the closest approximation this model supports.
+		if ssl, _ := strconv.ParseBool(server["ssl"]); ssl {
+			backend.UseSSL = true
+		}
+		backend.Backends = append(backend.Backends, HaproxyBackendServer{
+			Name: id,
+			IP:   server["ip"],
+			Port: uint16(port),
+		})
+	}
+
+	return backend
+}
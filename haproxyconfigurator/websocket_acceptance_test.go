@@ -0,0 +1,127 @@
+package haproxyconfigurator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWebSocketUpgradeSurvivesRenderedConfig stands up a local WebSocket
+// echo server, renders a config that proxies to it over a dedicated "_ws"
+// backend, and drives a haproxy process with that config to confirm
+// bidirectional frames survive the hop - the same coverage pattern used by
+// cloudfoundry/haproxy-boshrelease's acceptance suite.
+func TestWebSocketUpgradeSurvivesRenderedConfig(t *testing.T) {
+	if _, err := exec.LookPath("haproxy"); err != nil {
+		t.Skip("haproxy binary not available on PATH")
+	}
+
+	upgrader := websocket.Upgrader{}
+	echoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+	}))
+	defer echoServer.Close()
+
+	var configurator HaproxyConfigurator
+	configurator.Initialize()
+	configurator.AddListener(HaproxyListenerConfig{
+		Name:       "ws-test",
+		Hostname:   "ws.example.test",
+		ListenIP:   "127.0.0.1",
+		ListenPort: 18080,
+		Mode:       "http",
+		WebSocket:  true,
+		Backend: HaproxyBackend{
+			Name:          "ws_backend",
+			BalanceMethod: "roundrobin",
+			Backends: []HaproxyBackendServer{
+				{Name: "echo", IP: echoServerHost(echoServer.URL), Port: echoServerPort(echoServer.URL)},
+			},
+		},
+	})
+
+	config := configurator.Render()
+	haproxy, cleanup := startHaproxy(t, config)
+	defer cleanup()
+	time.Sleep(200 * time.Millisecond)
+
+	header := http.Header{}
+	header.Set("Host", "ws.example.test")
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:18080/", header)
+	if err != nil {
+		t.Fatalf("dial through haproxy failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(message) != "ping" {
+		t.Fatalf("expected echoed frame %q, got %q", "ping", message)
+	}
+
+	_ = haproxy
+}
+
+func echoServerHost(rawURL string) string {
+	parsed, _ := url.Parse(rawURL)
+	return parsed.Hostname()
+}
+
+func echoServerPort(rawURL string) uint16 {
+	parsed, _ := url.Parse(rawURL)
+	port, _ := strconv.ParseUint(parsed.Port(), 10, 16)
+	return uint16(port)
+}
+
+// startHaproxy writes config to a temp file and launches haproxy against
+// it, returning the running process alongside a cleanup func that tears it
+// down.
+func startHaproxy(t *testing.T, config string) (*exec.Cmd, func()) {
+	t.Helper()
+
+	configFile, err := os.CreateTemp("", "haproxy-*.cfg")
+	if err != nil {
+		t.Fatalf("failed to create temp config: %v", err)
+	}
+	if _, err := configFile.WriteString(config); err != nil {
+		t.Fatalf("failed to write temp config: %v", err)
+	}
+	configFile.Close()
+
+	cmd := exec.Command("haproxy", "-f", configFile.Name(), "-db")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start haproxy: %v", err)
+	}
+
+	return cmd, func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.Remove(configFile.Name())
+	}
+}